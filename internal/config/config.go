@@ -4,25 +4,161 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"sync"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Cfg struct {
-	MetricsPrefix      string  `yaml:"metricsPrefix"`
-	GraphqlURL         string  `yaml:"graphqlURL"`
-	GraphqlAPIToken    string  `yaml:"graphqlAPIToken"`
-	CacheExpire        int64   `yaml:"cacheExpire"`
-	QueryTimeout       int64   `yaml:"queryTimeout"`
-	FailFast           bool    `yaml:"failFast"`
-	ExtendCacheOnError bool    `yaml:"extendCacheOnError"`
-	Queries            []Query `yaml:"queries"`
+	MetricsPrefix string `yaml:"metricsPrefix"`
+	// Endpoints lists the GraphQL backends queries can be run against.
+	// Each Query picks one by name via Query.Endpoint; if only one
+	// endpoint is configured it is used implicitly.
+	Endpoints    []Endpoint `yaml:"endpoints"`
+	CacheExpire  int64      `yaml:"cacheExpire"`
+	QueryTimeout int64      `yaml:"queryTimeout"`
+	// FailFast, when set and exactly one query is configured, exits the
+	// exporter process on that query's first error instead of logging it
+	// and retrying on the next scrape. With more than one query running
+	// concurrently and independently, aborting the whole process over one
+	// query's failure would take down unrelated queries too, so FailFast
+	// is ignored once more than one query is configured.
+	FailFast           bool         `yaml:"failFast"`
+	ExtendCacheOnError bool         `yaml:"extendCacheOnError"`
+	Queries            []Query      `yaml:"queries"`
+	RemoteWrite        *RemoteWrite `yaml:"remoteWrite"`
+	// MaxConcurrentQueries bounds how many queries may be in flight at
+	// once. Queries beyond this limit wait for a slot instead of stalling
+	// the whole scrape behind the slowest endpoint.
+	MaxConcurrentQueries int `yaml:"maxConcurrentQueries"`
+	// EndpointLabel, when set, adds an "endpoint" label (holding the
+	// endpoint name) to every metric, so that metrics scraped from
+	// different GraphQL backends can coexist under the same series name.
+	EndpointLabel bool `yaml:"endpointLabel"`
+}
+
+// Endpoint is a named GraphQL backend a Query can run against.
+type Endpoint struct {
+	Name string       `yaml:"name"`
+	URL  string       `yaml:"url"`
+	Auth EndpointAuth `yaml:"auth"`
+	TLS  *TLSConfig   `yaml:"tls"`
+}
+
+// EndpointAuth configures how requests to an Endpoint authenticate. Type
+// selects which of the fields below apply: "bearer" (Token), "basic"
+// (Username/Password), or "oauth2" (OAuth2, a client-credentials exchange).
+// Headers are merged in regardless of Type, for backends needing custom
+// headers such as a tenant ID.
+type EndpointAuth struct {
+	Type     string            `yaml:"type"`
+	Token    string            `yaml:"token"`
+	Username string            `yaml:"username"`
+	Password string            `yaml:"password"`
+	OAuth2   *OAuth2Auth       `yaml:"oauth2"`
+	Headers  map[string]string `yaml:"headers"`
+}
+
+// OAuth2Auth exchanges client credentials for a bearer token, refetched
+// once the previously issued token expires.
+type OAuth2Auth struct {
+	ClientID     string   `yaml:"clientID"`
+	ClientSecret string   `yaml:"clientSecret"`
+	TokenURL     string   `yaml:"tokenURL"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// TLSConfig customizes the TLS client used to reach an Endpoint.
+type TLSConfig struct {
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	CAFile             string `yaml:"caFile"`
+	CertFile           string `yaml:"certFile"`
+	KeyFile            string `yaml:"keyFile"`
+}
+
+// RemoteWrite configures pushing scraped samples to one or more
+// Prometheus remote_write receivers (e.g. Mimir, Thanos, Cortex) on a
+// fixed interval, as an alternative or a complement to serving /metrics.
+type RemoteWrite struct {
+	Endpoints []RemoteWriteEndpoint `yaml:"endpoints"`
+}
+
+type RemoteWriteEndpoint struct {
+	Name            string            `yaml:"name"`
+	URL             string            `yaml:"url"`
+	Headers         map[string]string `yaml:"headers"`
+	BasicAuth       *BasicAuth        `yaml:"basicAuth"`
+	BearerToken     string            `yaml:"bearerToken"`
+	TenantHeader    string            `yaml:"tenantHeader"`
+	TenantID        string            `yaml:"tenantID"`
+	IntervalSeconds int64             `yaml:"intervalSeconds"`
+	TimeoutSeconds  int64             `yaml:"timeoutSeconds"`
+	MaxRetries      int               `yaml:"maxRetries"`
+	QueueCapacity   int               `yaml:"queueCapacity"`
+	BatchSize       int               `yaml:"batchSize"`
+}
+
+type BasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
 }
 
 type Query struct {
 	Query     string `yaml:"query"`
 	Subsystem string `yaml:"subsystem"`
-	Metrics   []Metric
+	// Name identifies the query in self metrics and logs. Defaults to
+	// Subsystem when unset.
+	Name string `yaml:"name"`
+	// Endpoint is the name of the Cfg.Endpoints entry to run this query
+	// against. Required when more than one endpoint is configured.
+	Endpoint string `yaml:"endpoint"`
+	// Mode selects how the query is executed: "" (the default) polls the
+	// query every CacheExpire seconds, while "subscription" opens a
+	// long-lived graphql-transport-ws connection and updates metrics from
+	// every message pushed by the server instead.
+	Mode string `yaml:"mode"`
+	// Interval and Timeout override the global CacheExpire/QueryTimeout
+	// for this query only. Zero means "use the global value".
+	Interval int64   `yaml:"interval"`
+	Timeout  int64   `yaml:"timeout"`
+	// Pagination, if set, causes the query to be run repeatedly, carrying
+	// the cursor/offset forward, until the result set is exhausted. Pages
+	// are merged before metrics are extracted.
+	Pagination *Pagination `yaml:"pagination"`
+	Metrics    []Metric
+}
+
+// Pagination describes how to page through a query that returns more
+// results than fit in a single response.
+type Pagination struct {
+	// Type is "relay" (cursor-based, the default) or "offset".
+	Type string `yaml:"type"`
+	// Variable is the name of the placeholder in Query, written as
+	// "$<variable>", that is substituted with the cursor (relay) or the
+	// numeric offset (offset) on every page; on the first page the cursor
+	// is the empty string and the offset is 0.
+	Variable string `yaml:"variable"`
+	// PageSize is the number of items requested per page. A page returning
+	// fewer items than PageSize is taken to be the last one (offset
+	// pagination only).
+	PageSize int `yaml:"pageSize"`
+	// PageSizeVariable is the name of a second placeholder in Query,
+	// written as "$<variable>", substituted with PageSize on every page
+	// (offset pagination only). If unset, Query's own page-size argument
+	// is not touched and must be hardcoded to match PageSize, or the
+	// short-page termination check above will misfire.
+	PageSizeVariable string `yaml:"pageSizeVariable"`
+	// CursorPath and HasNextPath locate the next cursor and the
+	// has-next-page flag in the raw JSON response (relay pagination only),
+	// e.g. "data.things.pageInfo.endCursor".
+	CursorPath  string `yaml:"cursorPath"`
+	HasNextPath string `yaml:"hasNextPath"`
+	// ItemsPath locates the page's result array in the raw JSON response
+	// (offset pagination only), used to detect a short, final page.
+	ItemsPath string `yaml:"itemsPath"`
+	// MaxPages caps how many pages are fetched, as a safety net against a
+	// misconfigured hasNextPath/itemsPath looping forever. Defaults to 1000.
+	MaxPages int `yaml:"maxPages"`
 }
 
 type Metric struct {
@@ -37,16 +173,64 @@ type Metric struct {
 var (
 	Config     *Cfg
 	ConfigPath string
+
+	// reloadMu serializes Reload calls against one another (SIGHUP and
+	// /-/reload can race). It does not make reads of Config itself safe
+	// against a concurrent Reload; that caveat already applied to Init's
+	// single assignment and is unchanged here.
+	reloadMu sync.Mutex
 )
 
 func Init(configPath string) error {
 	ConfigPath = configPath
+	cfg, err := parse(ConfigPath)
+	if err != nil {
+		return err
+	}
+	Config = cfg
+	slog.Info(fmt.Sprintf("Finished reading config from %s", configPath))
+	return nil
+}
+
+// Reload re-reads ConfigPath, validates the result, and swaps it in for
+// Config. Unlike Init, a reload that fails to parse or validate leaves the
+// running Config untouched.
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	cfg, err := parse(ConfigPath)
+	if err != nil {
+		return err
+	}
+	if err := validate(cfg); err != nil {
+		return err
+	}
+
+	Config = cfg
+
+	slog.Info(fmt.Sprintf("Reloaded config from %s", ConfigPath))
+	return nil
+}
+
+// Validate parses configPath and validates it without touching Config or
+// ConfigPath, for a config-check/dry-run entrypoint to call before the
+// exporter process is started or reloaded.
+func Validate(configPath string) error {
+	cfg, err := parse(configPath)
+	if err != nil {
+		return err
+	}
+	return validate(cfg)
+}
+
+func parse(configPath string) (*Cfg, error) {
 	content := []byte(`{}`)
-	_, err := os.Stat(ConfigPath)
+	_, err := os.Stat(configPath)
 	if !os.IsNotExist(err) {
-		content, err = os.ReadFile(ConfigPath)
+		content, err = os.ReadFile(configPath)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -54,19 +238,93 @@ func Init(configPath string) error {
 		content = []byte(`{}`)
 	}
 
-	err = yaml.Unmarshal(content, &Config)
-	if err != nil {
-		return err
+	var cfg *Cfg
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return nil, err
 	}
 	val, isSet := os.LookupEnv("GRAPHQLAPITOKEN")
-	if isSet {
-		Config.GraphqlAPIToken = val
+	if isSet && len(cfg.Endpoints) == 1 {
+		cfg.Endpoints[0].Auth.Type = "bearer"
+		cfg.Endpoints[0].Auth.Token = val
 	}
 
-	if Config.QueryTimeout == 0 {
-		Config.QueryTimeout = 60
+	if cfg.QueryTimeout == 0 {
+		cfg.QueryTimeout = 60
 	}
 
-	slog.Info(fmt.Sprintf("Finished reading config from %s", configPath))
+	if cfg.MaxConcurrentQueries == 0 {
+		cfg.MaxConcurrentQueries = 4
+	}
+
+	for i := range cfg.Queries {
+		q := &cfg.Queries[i]
+		if q.Name == "" {
+			q.Name = q.Subsystem
+		}
+		if q.Endpoint == "" && len(cfg.Endpoints) == 1 {
+			q.Endpoint = cfg.Endpoints[0].Name
+		}
+		if q.Pagination != nil {
+			if q.Pagination.Type == "" {
+				q.Pagination.Type = "relay"
+			}
+			if q.Pagination.MaxPages == 0 {
+				q.Pagination.MaxPages = 1000
+			}
+		}
+	}
+
+	if cfg.RemoteWrite != nil {
+		for i := range cfg.RemoteWrite.Endpoints {
+			ep := &cfg.RemoteWrite.Endpoints[i]
+			if ep.IntervalSeconds == 0 {
+				ep.IntervalSeconds = cfg.CacheExpire
+			}
+			if ep.IntervalSeconds == 0 {
+				// cacheExpire is also unset; time.NewTicker panics on a
+				// non-positive interval, so fall back to a sane default
+				// instead of crashing the exporter at startup.
+				ep.IntervalSeconds = 30
+			}
+			if ep.TimeoutSeconds == 0 {
+				ep.TimeoutSeconds = 30
+			}
+			if ep.QueueCapacity == 0 {
+				ep.QueueCapacity = 10000
+			}
+			if ep.BatchSize == 0 {
+				ep.BatchSize = 500
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// validate catches mistakes that parse's defaulting can't paper over, so a
+// bad Reload is rejected instead of leaving queries silently unable to run.
+func validate(cfg *Cfg) error {
+	endpointNames := make(map[string]bool, len(cfg.Endpoints))
+	for _, ep := range cfg.Endpoints {
+		if ep.Name == "" {
+			return fmt.Errorf("endpoints entry has no name")
+		}
+		endpointNames[ep.Name] = true
+	}
+	for _, q := range cfg.Queries {
+		if q.Endpoint == "" {
+			return fmt.Errorf("query %s has no endpoint configured", q.Name)
+		}
+		if !endpointNames[q.Endpoint] {
+			return fmt.Errorf("query %s references unknown endpoint %q", q.Name, q.Endpoint)
+		}
+	}
+	if cfg.RemoteWrite != nil {
+		for _, ep := range cfg.RemoteWrite.Endpoints {
+			if ep.IntervalSeconds <= 0 {
+				return fmt.Errorf("remoteWrite endpoint %s has a non-positive intervalSeconds", ep.Name)
+			}
+		}
+	}
 	return nil
 }
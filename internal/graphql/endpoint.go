@@ -0,0 +1,186 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vinted/graphql-exporter/internal/config"
+)
+
+// Endpoint is a configured GraphQL backend: an HTTP client plus whatever
+// authentication that backend requires. A single exporter process can hold
+// several Endpoints to scrape multiple GraphQL APIs under one set of
+// metrics.
+type Endpoint struct {
+	Name string
+	URL  string
+
+	client *http.Client
+	auth   config.EndpointAuth
+
+	tokenMu     sync.Mutex
+	oauthToken  string
+	oauthExpiry time.Time
+}
+
+// NewEndpoint builds an Endpoint from its configuration, including the TLS
+// client setup.
+func NewEndpoint(cfg config.Endpoint) (*Endpoint, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.TLS != nil {
+		tlsConfig, err := buildTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &Endpoint{
+		Name:   cfg.Name,
+		URL:    cfg.URL,
+		client: &http.Client{Transport: transport},
+		auth:   cfg.Auth,
+	}, nil
+}
+
+func buildTLSConfig(cfg *config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+type graphqlRequest struct {
+	Query string `json:"query"`
+}
+
+// Query executes query against the endpoint and returns the raw JSON
+// response body.
+func (e *Endpoint) Query(ctx context.Context, query string) ([]byte, error) {
+	body, err := json.Marshal(graphqlRequest{Query: query})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := e.applyAuth(ctx, req); err != nil {
+		return nil, err
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("graphql endpoint %s returned %s", e.Name, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// BearerToken returns the token to present on non-HTTP transports (e.g. the
+// websocket handshake for subscriptions). Only "bearer" auth is supported
+// there.
+func (e *Endpoint) BearerToken() string {
+	return e.auth.Token
+}
+
+func (e *Endpoint) applyAuth(ctx context.Context, req *http.Request) error {
+	switch e.auth.Type {
+	case "basic":
+		req.SetBasicAuth(e.auth.Username, e.auth.Password)
+	case "oauth2":
+		token, err := e.oauth2Token(ctx)
+		if err != nil {
+			return fmt.Errorf("oauth2 token exchange: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	default:
+		if e.auth.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+e.auth.Token)
+		}
+	}
+	for name, value := range e.auth.Headers {
+		req.Header.Set(name, value)
+	}
+	return nil
+}
+
+func (e *Endpoint) oauth2Token(ctx context.Context) (string, error) {
+	e.tokenMu.Lock()
+	defer e.tokenMu.Unlock()
+
+	if e.oauthToken != "" && time.Now().Before(e.oauthExpiry) {
+		return e.oauthToken, nil
+	}
+
+	cfg := e.auth.OAuth2
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", cfg.ClientID)
+	form.Set("client_secret", cfg.ClientSecret)
+	if len(cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(cfg.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("token request to %s returned %s", cfg.TokenURL, resp.Status)
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", err
+	}
+
+	e.oauthToken = tokenResp.AccessToken
+	e.oauthExpiry = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	return e.oauthToken, nil
+}
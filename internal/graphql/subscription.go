@@ -0,0 +1,176 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Message types of the graphql-transport-ws protocol.
+// See https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+const (
+	msgConnectionInit = "connection_init"
+	msgConnectionAck  = "connection_ack"
+	msgSubscribe      = "subscribe"
+	msgNext           = "next"
+	msgError          = "error"
+	msgComplete       = "complete"
+	msgPing           = "ping"
+	msgPong           = "pong"
+)
+
+const subscriptionProtocol = "graphql-transport-ws"
+
+var maxReconnectBackoff = time.Minute
+
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type subscribePayload struct {
+	Query string `json:"query"`
+}
+
+type nextPayload struct {
+	Data map[string]interface{} `json:"data"`
+}
+
+// Subscriber keeps a graphql-transport-ws connection open for a single
+// subscription query, reconnecting with exponential backoff whenever the
+// connection drops.
+type Subscriber struct {
+	URL      string
+	APIToken string
+	Query    string
+
+	// OnNext is called with the "data" object of every "next" message.
+	OnNext func(data map[string]interface{})
+	// OnConnected is called with true once the subscription is acked, and
+	// with false whenever the connection is lost.
+	OnConnected func(connected bool)
+	// OnMessage is called whenever a "next" message is received and its
+	// payload decodes (a payload that fails to decode is skipped), so
+	// callers can track how long it has been since the last update.
+	OnMessage func()
+}
+
+// Run blocks, keeping the subscription alive until ctx is cancelled.
+func (s *Subscriber) Run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		if err := s.runOnce(ctx); err != nil && ctx.Err() == nil {
+			slog.Error(fmt.Sprintf("subscription to %s failed: %s", s.URL, err))
+		}
+		if s.OnConnected != nil {
+			s.OnConnected(false)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+func (s *Subscriber) runOnce(ctx context.Context) error {
+	header := http.Header{}
+	if s.APIToken != "" {
+		header.Set("Authorization", "Bearer "+s.APIToken)
+	}
+
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = []string{subscriptionProtocol}
+	conn, _, err := dialer.DialContext(ctx, s.URL, header)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.WriteJSON(wsMessage{Type: msgConnectionInit}); err != nil {
+		return err
+	}
+	if err := s.waitForAck(conn); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(subscribePayload{Query: s.Query})
+	if err != nil {
+		return err
+	}
+	if err := conn.WriteJSON(wsMessage{ID: "1", Type: msgSubscribe, Payload: payload}); err != nil {
+		return err
+	}
+
+	for {
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return err
+		}
+
+		switch msg.Type {
+		case msgPing:
+			if err := conn.WriteJSON(wsMessage{Type: msgPong}); err != nil {
+				return err
+			}
+		case msgPong:
+			// keepalive acknowledged, nothing to do
+		case msgNext:
+			var next nextPayload
+			if err := json.Unmarshal(msg.Payload, &next); err != nil {
+				slog.Error(fmt.Sprintf("subscription payload decode error: %s", err))
+				continue
+			}
+			if s.OnMessage != nil {
+				s.OnMessage()
+			}
+			if s.OnNext != nil {
+				s.OnNext(next.Data)
+			}
+		case msgError:
+			return fmt.Errorf("subscription error: %s", string(msg.Payload))
+		case msgComplete:
+			return nil
+		}
+	}
+}
+
+// waitForAck blocks until the server acks connection_init, which the
+// graphql-transport-ws protocol requires before a client may subscribe;
+// spec-compliant servers close the socket on an early subscribe. A ping
+// sent before the ack is answered in place, since the protocol allows the
+// server to do that while connection_init is still pending.
+func (s *Subscriber) waitForAck(conn *websocket.Conn) error {
+	for {
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return err
+		}
+
+		switch msg.Type {
+		case msgConnectionAck:
+			if s.OnConnected != nil {
+				s.OnConnected(true)
+			}
+			return nil
+		case msgPing:
+			if err := conn.WriteJSON(wsMessage{Type: msgPong}); err != nil {
+				return err
+			}
+		case msgError:
+			return fmt.Errorf("connection_init rejected: %s", string(msg.Payload))
+		default:
+			return fmt.Errorf("unexpected message %q before connection_ack", msg.Type)
+		}
+	}
+}
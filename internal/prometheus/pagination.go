@@ -0,0 +1,137 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// getPaginatedQuerySetMetrics repeatedly runs q.Query, substituting the
+// pagination variable with the cursor (relay) or offset (offset) carried
+// over from the previous page, merging every page's data and running
+// metric extraction once over the combined result.
+func (collector *GraphqlCollector) getPaginatedQuerySetMetrics(q *QuerySet) error {
+	if q.Endpoint == nil {
+		return fmt.Errorf("query %s has no usable endpoint", q.Name)
+	}
+
+	p := q.Pagination
+	merged := map[string]interface{}{}
+	cursor := ""
+	offset := 0
+
+pageLoop:
+	for page := 0; page < p.MaxPages; page++ {
+		query := q.Query
+		switch p.Type {
+		case "offset":
+			query = strings.ReplaceAll(query, "$"+p.Variable, strconv.Itoa(offset))
+			if p.PageSizeVariable != "" {
+				query = strings.ReplaceAll(query, "$"+p.PageSizeVariable, strconv.Itoa(p.PageSize))
+			}
+		default:
+			query = strings.ReplaceAll(query, "$"+p.Variable, cursor)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(q.TimeoutSeconds))
+		result, err := q.Endpoint.Query(ctx, query)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("query error: %w", err)
+		}
+
+		var root map[string]interface{}
+		if err := json.Unmarshal(result, &root); err != nil {
+			return fmt.Errorf("unmarshal error: %w", err)
+		}
+
+		if data, ok := root["data"].(map[string]interface{}); ok {
+			mergeInto(merged, data)
+		}
+
+		switch p.Type {
+		case "offset":
+			items, _ := lookupPath(root, p.ItemsPath)
+			offset += p.PageSize
+			if arrayLen(items) < p.PageSize {
+				break pageLoop
+			}
+		default:
+			hasNext, _ := lookupPath(root, p.HasNextPath)
+			next, ok := lookupPath(root, p.CursorPath)
+			if hasNext != true || !ok {
+				break pageLoop
+			}
+			cursor = fmt.Sprintf("%v", next)
+			if cursor == "" {
+				break pageLoop
+			}
+		}
+	}
+
+	queryCtx := context.WithValue(context.Background(), "query", q.Query)
+	for _, m := range q.Metrics {
+		metricCtx := context.WithValue(queryCtx, "metric", m.Config.Name)
+		m.Extractor.ExtractMetrics(merged, func(value string, labels []string) {
+			m.apply(metricCtx, value, q.labelValues(labels))
+		})
+	}
+	return nil
+}
+
+// mergeInto merges src into dst, concatenating slices and recursively
+// merging maps found at matching keys so that repeated list fields (e.g. a
+// "nodes"/"edges" array) accumulate across pages instead of overwriting.
+func mergeInto(dst, src map[string]interface{}) {
+	for key, value := range src {
+		existing, ok := dst[key]
+		if !ok {
+			dst[key] = value
+			continue
+		}
+		switch existingTyped := existing.(type) {
+		case []interface{}:
+			if newSlice, ok := value.([]interface{}); ok {
+				dst[key] = append(existingTyped, newSlice...)
+				continue
+			}
+		case map[string]interface{}:
+			if newMap, ok := value.(map[string]interface{}); ok {
+				mergeInto(existingTyped, newMap)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+}
+
+// lookupPath walks a dot-separated path (e.g. "data.things.pageInfo.endCursor")
+// through nested maps decoded from a GraphQL JSON response.
+func lookupPath(root map[string]interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return nil, false
+	}
+	var current interface{} = root
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func arrayLen(v interface{}) int {
+	arr, ok := v.([]interface{})
+	if !ok {
+		return 0
+	}
+	return len(arr)
+}
@@ -6,9 +6,13 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -20,19 +24,88 @@ import (
 
 var latencyHistogramBuckets = []float64{.1, .25, .5, 1, 2.5, 5, 10, 15, 20, 30, 40, 50, 60, 90, 150, 210, 270, 330, 390, 450, 500, 600, 1200, 1800, 2700, 3600}
 
+var (
+	subscriptionConnected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "graphql_exporter",
+			Name:      "subscription_connected",
+			Help:      "Whether the GraphQL subscription websocket is currently connected (1) or not (0).",
+		},
+		[]string{"subsystem"},
+	)
+	subscriptionLastMessageAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "graphql_exporter",
+			Name:      "subscription_last_message_age_seconds",
+			Help:      "Seconds since the last message was received on the GraphQL subscription.",
+		},
+		[]string{"subsystem"},
+	)
+)
+
+var (
+	queryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "graphql_exporter",
+			Name:      "query_duration_seconds",
+			Help:      "Duration of GraphQL query execution.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"query", "subsystem"},
+	)
+	queryErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "graphql_exporter",
+			Name:      "query_errors_total",
+			Help:      "Total number of failed GraphQL query executions.",
+		},
+		[]string{"query", "subsystem"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(subscriptionConnected, subscriptionLastMessageAge, queryDuration, queryErrors)
+}
+
 type Graphql struct {
 	Data map[string]interface{}
 }
 
 type QuerySet struct {
-	Query   string
-	Metrics []*Metric
+	Query           string
+	Name            string
+	Subsystem       string
+	Mode            string
+	IntervalSeconds int64
+	TimeoutSeconds  int64
+	Pagination      *config.Pagination
+	Endpoint        *graphql.Endpoint
+	EndpointName    string
+	Metrics         []*Metric
+
+	// cachedAt is the unix timestamp this QuerySet's metrics were last
+	// refreshed at. Each QuerySet ages out independently so a slow query
+	// doesn't hold back faster ones sharing the same scrape.
+	cachedAt int64
+	// refreshing is set while a refresh for this QuerySet is in flight, so
+	// a slow query isn't queued twice.
+	refreshing int32
+
+	// lastMessageUnix is the unix timestamp of the last message received on
+	// a subscription QuerySet's websocket. Unused for polled query sets.
+	lastMessageUnix int64
 }
 
 type Metric struct {
 	Collector prometheus.Collector
 	Config    config.Metric
 	Extractor Extractor
+
+	// counterMu guards counterValues, which remembers the last value observed
+	// for each label set so Counters can be derived from cumulative GraphQL
+	// fields instead of being incremented by a fixed step.
+	counterMu     sync.Mutex
+	counterValues map[string]float64
 }
 type Label struct {
 	Name  string
@@ -40,21 +113,84 @@ type Label struct {
 }
 
 type GraphqlCollector struct {
-	cachedQuerySet   []*QuerySet
-	cachedAt         int64
-	updaterIsRunning bool
-	updaterMu        sync.Mutex
-	accessMu         sync.Mutex
-	graphqlURL       string
+	// mu guards cachedQuerySet and querySemaphore, which Reload replaces
+	// wholesale. Collect and refreshQuerySet only hold it long enough to
+	// take a local copy, so a reload never blocks on or is blocked by an
+	// in-flight scrape or query.
+	mu             sync.RWMutex
+	cachedQuerySet []*QuerySet
+	// querySemaphore bounds how many queries may be in flight at once, sized
+	// from config.Cfg.MaxConcurrentQueries.
+	querySemaphore chan struct{}
+
+	// subCancel stops the subscription goroutines started for the current
+	// cachedQuerySet, so Reload can replace them with goroutines for the
+	// reloaded query set instead of leaking the old ones.
+	subCancel context.CancelFunc
 }
 
 // Build Prometheux MetricVec with label dimensions.
 func newGraphqlCollector() *GraphqlCollector {
+	return &GraphqlCollector{
+		cachedQuerySet: buildQuerySets(nil),
+		querySemaphore: make(chan struct{}, config.Config.MaxConcurrentQueries),
+	}
+}
+
+// metricIdentity derives a key for m, scoped to the query it belongs to,
+// from every field that feeds into the Collector buildQuerySets creates for
+// it. Two builds that produce the same key are guaranteed to want the same
+// collector and label set, so it's safe for buildQuerySets to reuse the
+// previous build's *Metric (and the counter/gauge state it's accumulated)
+// instead of creating a fresh one.
+func metricIdentity(subsystem, queryName string, m config.Metric) string {
+	return strings.Join([]string{
+		config.Config.MetricsPrefix, subsystem, queryName, m.MetricType, m.Name, m.Value,
+		strings.Join(m.Labels, ","), m.Description,
+		strconv.FormatBool(config.Config.EndpointLabel),
+	}, "\x1f")
+}
+
+// buildQuerySets turns config.Config's endpoints and queries into the
+// QuerySets and their metric collectors. Both newGraphqlCollector and
+// GraphqlCollector.Reload use it, so a reload sees exactly the same
+// construction as a cold start, except that previous's *Metrics are reused
+// wherever metricIdentity says a query/metric definition is unchanged —
+// preserving that metric's collector and accumulated counter state across
+// the reload instead of resetting it to zero.
+func buildQuerySets(previous []*QuerySet) []*QuerySet {
+	previousMetrics := make(map[string]*Metric)
+	for _, q := range previous {
+		for _, m := range q.Metrics {
+			previousMetrics[metricIdentity(q.Subsystem, q.Name, m.Config)] = m
+		}
+	}
+
+	endpoints := make(map[string]*graphql.Endpoint, len(config.Config.Endpoints))
+	for _, ep := range config.Config.Endpoints {
+		endpoint, err := graphql.NewEndpoint(ep)
+		if err != nil {
+			slog.Error(fmt.Sprintf("failed to configure endpoint %s: %s", ep.Name, err))
+			continue
+		}
+		endpoints[ep.Name] = endpoint
+	}
+
 	var cachedQuerySet []*QuerySet
 
 	for _, q := range config.Config.Queries {
+		endpoint := endpoints[q.Endpoint]
+		if endpoint == nil {
+			slog.Error(fmt.Sprintf("query %s references unknown endpoint %q", q.Name, q.Endpoint))
+		}
+
 		var metrics []*Metric
 		for _, m := range q.Metrics {
+			if prev, ok := previousMetrics[metricIdentity(q.Subsystem, q.Name, m)]; ok {
+				metrics = append(metrics, prev)
+				continue
+			}
+
 			var collector prometheus.Collector
 			var name string
 			var labels []string
@@ -75,6 +211,9 @@ func newGraphqlCollector() *GraphqlCollector {
 				label = strings.Replace(label, ".", "_", -1)
 				labels = append(labels, label)
 			}
+			if config.Config.EndpointLabel {
+				labels = append(labels, "endpoint")
+			}
 			switch {
 			case m.MetricType == "histogram":
 				collector = prometheus.NewHistogramVec(
@@ -86,6 +225,16 @@ func newGraphqlCollector() *GraphqlCollector {
 						Buckets:   latencyHistogramBuckets,
 					},
 					labels)
+			case m.MetricType == "counter":
+				collector = prometheus.NewCounterVec(
+					prometheus.CounterOpts{
+						Namespace: config.Config.MetricsPrefix,
+						Subsystem: q.Subsystem,
+						Name:      name,
+						Help:      m.Description,
+					},
+					labels,
+				)
 			default:
 				collector = prometheus.NewGaugeVec(
 					prometheus.GaugeOpts{
@@ -98,131 +247,238 @@ func newGraphqlCollector() *GraphqlCollector {
 				)
 			}
 			metrics = append(metrics, &Metric{
-				Collector: collector,
-				Config:    m,
-				Extractor: extractor,
+				Collector:     collector,
+				Config:        m,
+				Extractor:     extractor,
+				counterValues: make(map[string]float64),
 			})
 		}
+		interval := q.Interval
+		if interval == 0 {
+			interval = config.Config.CacheExpire
+		}
+		timeout := q.Timeout
+		if timeout == 0 {
+			timeout = config.Config.QueryTimeout
+		}
 		querySet := &QuerySet{
-			Query:   q.Query,
-			Metrics: metrics,
+			Query:           q.Query,
+			Name:            q.Name,
+			Subsystem:       q.Subsystem,
+			Mode:            q.Mode,
+			IntervalSeconds: interval,
+			TimeoutSeconds:  timeout,
+			Pagination:      q.Pagination,
+			Endpoint:        endpoint,
+			EndpointName:    q.Endpoint,
+			Metrics:         metrics,
 		}
 		cachedQuerySet = append(cachedQuerySet, querySet)
 	}
 
-	return &GraphqlCollector{
-		cachedQuerySet: cachedQuerySet,
-		updaterMu:      sync.Mutex{},
-		accessMu:       sync.Mutex{},
-		graphqlURL:     config.Config.GraphqlURL,
-	}
+	return cachedQuerySet
 }
 
-func (collector *GraphqlCollector) getMetrics() error {
-	var gql *Graphql
+// labelValues appends the endpoint label value to labels when
+// Cfg.EndpointLabel is enabled, matching the "endpoint" label name appended
+// to a metric's label set in buildQuerySets.
+func (q *QuerySet) labelValues(labels []string) []string {
+	if !config.Config.EndpointLabel {
+		return labels
+	}
+	return append(labels, q.EndpointName)
+}
 
-	for _, q := range collector.cachedQuerySet {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(config.Config.QueryTimeout))
-		queryCtx := context.WithValue(ctx, "query", q.Query)
-		result, err := graphql.GraphqlQuery(ctx, q.Query)
-		cancel()
+// apply converts an extracted value into the right Prometheus operation for
+// this metric's collector type, including counter delta/reset handling.
+func (m *Metric) apply(ctx context.Context, value string, labels []string) {
+	if value == "" {
+		return
+	}
+	switch v := m.Collector.(type) {
+	case *prometheus.HistogramVec:
+		f, err := strconv.ParseFloat(value, 64)
 		if err != nil {
-			if config.Config.FailFast {
-				return err
-			} else {
-				slog.Error(fmt.Sprintf("query error: %s", err))
-				continue
-			}
+			slog.ErrorContext(ctx, "fail to convert metric to float", slog.String("value", value))
+			return
 		}
-
-		err = json.Unmarshal(result, &gql)
+		v.WithLabelValues(labels...).Observe(f)
+	case *prometheus.GaugeVec:
+		f, err := strconv.ParseFloat(value, 64)
 		if err != nil {
-			if config.Config.FailFast {
-				return err
-			} else {
-				slog.Error(fmt.Sprintf("unmarshal error: %s", err))
-				continue
+			slog.ErrorContext(ctx, "fail to convert metric to float", slog.String("value", value))
+			return
+		}
+		v.WithLabelValues(labels...).Set(f)
+	case *prometheus.CounterVec:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			slog.ErrorContext(ctx, "fail to convert metric to float", slog.String("value", value))
+			return
+		}
+		key := strings.Join(labels, "\x1f")
+		m.counterMu.Lock()
+		previous, seen := m.counterValues[key]
+		m.counterValues[key] = f
+		m.counterMu.Unlock()
+
+		// On the first observation for this label set there is no
+		// previous value to diff against, so only establish the
+		// baseline instead of adding f itself: f is the upstream's
+		// cumulative total so far, and adding all of it here would
+		// show up as one false spike (and again on every restart).
+		delta := 0.0
+		if seen {
+			delta = f - previous
+			if delta < 0 {
+				// The counter went backwards, e.g. the upstream
+				// total was reset. Don't count the drop itself;
+				// resume accumulating from the new value.
+				delta = 0
 			}
 		}
-		data := gql.Data
-		if data == nil {
+		v.WithLabelValues(labels...).Add(delta)
+	default:
+		slog.Error(fmt.Sprintf("unsuported collector type %v", v))
+	}
+}
+
+// getQuerySetMetrics runs a single QuerySet's query and feeds the result
+// into its metrics' collectors.
+func (collector *GraphqlCollector) getQuerySetMetrics(q *QuerySet) error {
+	if q.Pagination != nil {
+		return collector.getPaginatedQuerySetMetrics(q)
+	}
+	if q.Endpoint == nil {
+		return fmt.Errorf("query %s has no usable endpoint", q.Name)
+	}
+
+	var gql *Graphql
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(q.TimeoutSeconds))
+	defer cancel()
+	queryCtx := context.WithValue(ctx, "query", q.Query)
+
+	result, err := q.Endpoint.Query(ctx, q.Query)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+
+	if err := json.Unmarshal(result, &gql); err != nil {
+		return fmt.Errorf("unmarshal error: %w", err)
+	}
+	data := gql.Data
+	if data == nil {
+		return nil
+	}
+	for _, m := range q.Metrics {
+		metricCtx := context.WithValue(queryCtx, "metric", m.Config.Name)
+		callbackFunc := func(value string, labels []string) {
+			m.apply(metricCtx, value, q.labelValues(labels))
+		}
+		m.Extractor.ExtractMetrics(data, callbackFunc)
+	}
+	return nil
+}
+
+// startSubscriptions opens one persistent websocket per subscription-mode
+// QuerySet in querySets and keeps their collectors updated from pushed
+// messages for as long as ctx is alive.
+func (collector *GraphqlCollector) startSubscriptions(ctx context.Context, querySets []*QuerySet) {
+	for _, q := range querySets {
+		if q.Mode != "subscription" {
 			continue
 		}
-		for _, m := range q.Metrics {
-			metricCtx := context.WithValue(queryCtx, "metric", m.Config.Name)
-			callbackFunc := func(value string, labels []string) {
-				if value == "" {
-					return
+		if q.Endpoint == nil {
+			slog.Error(fmt.Sprintf("subscription %s has no usable endpoint", q.Name))
+			continue
+		}
+		q := q
+		subscriber := &graphql.Subscriber{
+			URL:      q.Endpoint.URL,
+			APIToken: q.Endpoint.BearerToken(),
+			Query:    q.Query,
+			OnConnected: func(connected bool) {
+				value := 0.0
+				if connected {
+					value = 1
 				}
-				switch v := m.Collector.(type) {
-				case *prometheus.HistogramVec:
-					f, err := strconv.ParseFloat(value, 64)
-					if err != nil {
-						slog.ErrorContext(metricCtx, "fail to convert metric to float", slog.String("value", value))
-					}
-					v.WithLabelValues(labels...).Observe(f)
-				case *prometheus.GaugeVec:
-					f, err := strconv.ParseFloat(value, 64)
-					if err != nil {
-						slog.ErrorContext(metricCtx, "fail to convert metric to float", slog.String("value", value))
-					}
-					v.WithLabelValues(labels...).Set(f)
-				case *prometheus.CounterVec:
-					f, err := strconv.ParseFloat(value, 64)
-					if err != nil || f < 0 {
-						f = 1
-					}
-					v.WithLabelValues(labels...).Add(f)
-				default:
-					slog.Error(fmt.Sprintf("unsuported collector type %v", v))
+				subscriptionConnected.WithLabelValues(q.Subsystem).Set(value)
+			},
+			OnMessage: func() {
+				atomic.StoreInt64(&q.lastMessageUnix, time.Now().Unix())
+			},
+			OnNext: func(data map[string]interface{}) {
+				queryCtx := context.WithValue(ctx, "query", q.Query)
+				for _, m := range q.Metrics {
+					metricCtx := context.WithValue(queryCtx, "metric", m.Config.Name)
+					m.Extractor.ExtractMetrics(data, func(value string, labels []string) {
+						m.apply(metricCtx, value, q.labelValues(labels))
+					})
 				}
-			}
-			m.Extractor.ExtractMetrics(data, callbackFunc)
+			},
 		}
+		go subscriber.Run(ctx)
 	}
-	return nil
 }
 
 func (collector *GraphqlCollector) Describe(ch chan<- *prometheus.Desc) {}
 
-func (collector *GraphqlCollector) updateMetrics() error {
-	if time.Now().Unix()-collector.cachedAt > config.Config.CacheExpire {
-		collector.accessMu.Lock()
-		defer collector.accessMu.Unlock()
-		err := collector.getMetrics()
+// refreshQuerySet refreshes q in the background, bounded by
+// collector.querySemaphore, if its cache has expired. It is a no-op if q is
+// subscription-driven or a refresh for q is already in flight.
+func (collector *GraphqlCollector) refreshQuerySet(q *QuerySet) {
+	if q.Mode == "subscription" {
+		return
+	}
+	if time.Now().Unix()-atomic.LoadInt64(&q.cachedAt) <= q.IntervalSeconds {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&q.refreshing, 0, 1) {
+		return
+	}
+
+	collector.mu.RLock()
+	sem := collector.querySemaphore
+	collector.mu.RUnlock()
+
+	go func() {
+		sem <- struct{}{}
+		defer func() { <-sem }()
+		defer atomic.StoreInt32(&q.refreshing, 0)
+
+		start := time.Now()
+		err := collector.getQuerySetMetrics(q)
+		queryDuration.WithLabelValues(q.Name, q.Subsystem).Observe(time.Since(start).Seconds())
 		if err != nil {
-			slog.Error(fmt.Sprintf("error collecting metrics: %s", err))
+			queryErrors.WithLabelValues(q.Name, q.Subsystem).Inc()
+			slog.Error(fmt.Sprintf("error collecting metrics for query %s: %s", q.Name, err))
+			if config.Config.FailFast && len(config.Config.Queries) == 1 {
+				slog.Error("failFast enabled: exiting after query error")
+				os.Exit(1)
+			}
 			if config.Config.ExtendCacheOnError {
-				collector.cachedAt = time.Now().Unix()
+				atomic.StoreInt64(&q.cachedAt, time.Now().Unix())
 			}
-			return err
-		} else {
-			collector.cachedAt = time.Now().Unix()
+			return
 		}
-	}
-	return nil
-}
-
-func (collector *GraphqlCollector) atomicUpdate(ch chan<- prometheus.Metric) {
-	collector.updaterMu.Lock()
-	start := !collector.updaterIsRunning
-	collector.updaterIsRunning = true
-	collector.updaterMu.Unlock()
-	if start {
-		go func() {
-			collector.updateMetrics()
-			collector.updaterMu.Lock()
-			collector.updaterIsRunning = false
-			collector.updaterMu.Unlock()
-		}()
-	}
+		atomic.StoreInt64(&q.cachedAt, time.Now().Unix())
+	}()
 }
 
 func (collector *GraphqlCollector) Collect(ch chan<- prometheus.Metric) {
-	collector.atomicUpdate(ch)
-	collector.accessMu.Lock()
-	defer collector.accessMu.Unlock()
-	for _, querySet := range collector.cachedQuerySet {
+	collector.mu.RLock()
+	querySets := collector.cachedQuerySet
+	collector.mu.RUnlock()
+
+	now := time.Now().Unix()
+	for _, querySet := range querySets {
+		collector.refreshQuerySet(querySet)
+		if querySet.Mode == "subscription" {
+			if last := atomic.LoadInt64(&querySet.lastMessageUnix); last > 0 {
+				subscriptionLastMessageAge.WithLabelValues(querySet.Subsystem).Set(float64(now - last))
+			}
+		}
 		for _, metric := range querySet.Metrics {
 			switch c := metric.Collector.(type) {
 			case *prometheus.CounterVec, *prometheus.GaugeVec:
@@ -238,6 +494,41 @@ func (collector *GraphqlCollector) Collect(ch chan<- prometheus.Metric) {
 	}
 }
 
+// Reload re-reads and validates config.Config, then swaps in the rebuilt
+// QuerySets and restarts subscriptions against them. Query/metric
+// definitions that didn't change keep their existing collector and
+// accumulated counter/gauge state instead of resetting to zero. The
+// previous QuerySets are left to finish any in-flight refresh on their own;
+// only their subscriptions are torn down explicitly, since those would
+// otherwise run forever.
+func (collector *GraphqlCollector) Reload(ctx context.Context) error {
+	if err := config.Reload(); err != nil {
+		return fmt.Errorf("reload config: %w", err)
+	}
+
+	collector.mu.RLock()
+	previous := collector.cachedQuerySet
+	collector.mu.RUnlock()
+
+	querySets := buildQuerySets(previous)
+	subCtx, cancel := context.WithCancel(ctx)
+
+	collector.mu.Lock()
+	collector.cachedQuerySet = querySets
+	collector.querySemaphore = make(chan struct{}, config.Config.MaxConcurrentQueries)
+	previousCancel := collector.subCancel
+	collector.subCancel = cancel
+	collector.mu.Unlock()
+
+	if previousCancel != nil {
+		previousCancel()
+	}
+	collector.startSubscriptions(subCtx, querySets)
+
+	slog.Info("Reloaded GraphQL query set from config")
+	return nil
+}
+
 func staticPage(w http.ResponseWriter, req *http.Request) {
 	page := `<html>
     <head><title>Graphql Exporter</title></head>
@@ -250,12 +541,51 @@ func staticPage(w http.ResponseWriter, req *http.Request) {
 }
 
 func Start(httpListenAddress string) {
-	graphql := newGraphqlCollector()
-	prometheus.MustRegister(graphql)
+	graphqlCollector := newGraphqlCollector()
+	prometheus.MustRegister(graphqlCollector)
+
+	subCtx, subCancel := context.WithCancel(context.Background())
+	graphqlCollector.subCancel = subCancel
+	graphqlCollector.startSubscriptions(subCtx, graphqlCollector.cachedQuerySet)
+
+	if config.Config.RemoteWrite != nil && len(config.Config.RemoteWrite.Endpoints) > 0 {
+		// Gather from a registry holding only graphqlCollector, not
+		// prometheus.DefaultGatherer, so remote_write carries the GraphQL
+		// query metrics and not the process/go/self metrics also served
+		// on /metrics.
+		graphqlRegistry := prometheus.NewRegistry()
+		graphqlRegistry.MustRegister(graphqlCollector)
+		writer := newRemoteWriter(graphqlRegistry)
+		writer.Run(context.Background(), config.Config.RemoteWrite.Endpoints)
+	}
+
+	reloadFromSighup(graphqlCollector)
 
 	router := mux.NewRouter()
 	router.HandleFunc("/", staticPage)
 	router.Path("/metrics").Handler(promhttp.Handler())
+	router.Methods(http.MethodPost).Path("/-/reload").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := graphqlCollector.Reload(req.Context()); err != nil {
+			slog.Error(fmt.Sprintf("config reload failed: %s", err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
 	slog.Info("Listening on " + httpListenAddress)
 	slog.Error(fmt.Sprintf("%s", http.ListenAndServe(httpListenAddress, router)))
 }
+
+// reloadFromSighup triggers graphqlCollector.Reload on every SIGHUP, mirroring
+// the POST /-/reload endpoint for operators who prefer `kill -HUP`.
+func reloadFromSighup(graphqlCollector *GraphqlCollector) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			if err := graphqlCollector.Reload(context.Background()); err != nil {
+				slog.Error(fmt.Sprintf("config reload failed: %s", err))
+			}
+		}
+	}()
+}
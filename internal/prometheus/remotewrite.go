@@ -0,0 +1,272 @@
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vinted/graphql-exporter/internal/config"
+)
+
+var (
+	remoteWriteQueueLength = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "cortex",
+			Subsystem: "remote_write",
+			Name:      "queue_length",
+			Help:      "Number of batches currently queued for remote_write send.",
+		},
+		[]string{"remote"},
+	)
+	remoteWriteSendFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "cortex",
+			Subsystem: "remote_write",
+			Name:      "send_failures_total",
+			Help:      "Total number of failed remote_write send attempts.",
+		},
+		[]string{"remote"},
+	)
+	remoteWriteQueueDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "cortex",
+			Subsystem: "remote_write",
+			Name:      "queue_dropped_total",
+			Help:      "Total number of batches dropped because the remote_write queue was full.",
+		},
+		[]string{"remote"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(remoteWriteQueueLength, remoteWriteSendFailures, remoteWriteQueueDropped)
+}
+
+// remoteWriter pushes the metrics exposed by a prometheus.Gatherer to a set
+// of configured remote_write endpoints on their own intervals, through a
+// bounded per-endpoint queue of pending batches sized by QueueCapacity.
+type remoteWriter struct {
+	gatherer prometheus.Gatherer
+	client   *http.Client
+}
+
+func newRemoteWriter(gatherer prometheus.Gatherer) *remoteWriter {
+	return &remoteWriter{
+		gatherer: gatherer,
+		client:   &http.Client{},
+	}
+}
+
+// Run starts one goroutine per endpoint and returns at once; the goroutines
+// keep running until ctx is cancelled.
+func (rw *remoteWriter) Run(ctx context.Context, endpoints []config.RemoteWriteEndpoint) {
+	for _, ep := range endpoints {
+		go rw.runEndpoint(ctx, ep)
+	}
+}
+
+// runEndpoint gathers and batches samples for ep on its own interval,
+// enqueuing each batch for send, and drains that queue on a separate
+// goroutine so a slow or unreachable remote doesn't stall the next gather.
+func (rw *remoteWriter) runEndpoint(ctx context.Context, ep config.RemoteWriteEndpoint) {
+	queue := make(chan []prompb.TimeSeries, ep.QueueCapacity)
+	go rw.drainQueue(ctx, ep, queue)
+
+	ticker := time.NewTicker(time.Duration(ep.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			close(queue)
+			return
+		case <-ticker.C:
+			if err := rw.enqueue(ep, queue); err != nil {
+				slog.Error(fmt.Sprintf("remote_write gather for %s failed: %s", ep.Name, err))
+			}
+		}
+	}
+}
+
+// enqueue gathers the current samples for ep, batches them by BatchSize, and
+// pushes each batch onto queue. A batch that doesn't fit because the queue
+// is already at QueueCapacity is dropped rather than blocking the next
+// gather.
+func (rw *remoteWriter) enqueue(ep config.RemoteWriteEndpoint, queue chan []prompb.TimeSeries) error {
+	families, err := rw.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	series := familiesToTimeSeries(families)
+	for start := 0; start < len(series); start += ep.BatchSize {
+		end := start + ep.BatchSize
+		if end > len(series) {
+			end = len(series)
+		}
+		select {
+		case queue <- series[start:end]:
+		default:
+			remoteWriteQueueDropped.WithLabelValues(ep.Name).Inc()
+			slog.Error(fmt.Sprintf("remote_write queue for %s is full (capacity %d), dropping a batch", ep.Name, ep.QueueCapacity))
+		}
+	}
+	remoteWriteQueueLength.WithLabelValues(ep.Name).Set(float64(len(queue)))
+	return nil
+}
+
+// drainQueue sends every batch enqueued for ep until queue is closed.
+func (rw *remoteWriter) drainQueue(ctx context.Context, ep config.RemoteWriteEndpoint, queue chan []prompb.TimeSeries) {
+	for batch := range queue {
+		if err := rw.sendBatch(ctx, ep, batch); err != nil {
+			slog.Error(fmt.Sprintf("remote_write to %s failed: %s", ep.Name, err))
+		}
+		remoteWriteQueueLength.WithLabelValues(ep.Name).Set(float64(len(queue)))
+	}
+}
+
+func (rw *remoteWriter) sendBatch(ctx context.Context, ep config.RemoteWriteEndpoint, batch []prompb.TimeSeries) error {
+	data, err := proto.Marshal(&prompb.WriteRequest{Timeseries: batch})
+	if err != nil {
+		return err
+	}
+	compressed := snappy.Encode(nil, data)
+
+	backoff := time.Second
+	var lastErr error
+	for attempt := 0; attempt <= ep.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if lastErr = rw.post(ctx, ep, compressed); lastErr == nil {
+			return nil
+		}
+		remoteWriteSendFailures.WithLabelValues(ep.Name).Inc()
+	}
+	return lastErr
+}
+
+func (rw *remoteWriter) post(ctx context.Context, ep config.RemoteWriteEndpoint, body []byte) error {
+	reqCtx, cancel := context.WithTimeout(ctx, time.Duration(ep.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for name, value := range ep.Headers {
+		req.Header.Set(name, value)
+	}
+	if ep.TenantHeader != "" && ep.TenantID != "" {
+		req.Header.Set(ep.TenantHeader, ep.TenantID)
+	}
+	if ep.BasicAuth != nil {
+		req.SetBasicAuth(ep.BasicAuth.Username, ep.BasicAuth.Password)
+	}
+	if ep.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+ep.BearerToken)
+	}
+
+	resp, err := rw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("remote_write %s returned %s", ep.URL, resp.Status)
+	}
+	return nil
+}
+
+func familiesToTimeSeries(families []*dto.MetricFamily) []prompb.TimeSeries {
+	now := time.Now().UnixMilli()
+	var series []prompb.TimeSeries
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			baseLabels := make([]prompb.Label, 0, len(metric.GetLabel()))
+			for _, lp := range metric.GetLabel() {
+				baseLabels = append(baseLabels, prompb.Label{Name: lp.GetName(), Value: lp.GetValue()})
+			}
+
+			if metric.Histogram != nil {
+				series = append(series, histogramSeries(family.GetName(), baseLabels, metric.Histogram, now)...)
+				continue
+			}
+
+			value, ok := metricValue(metric)
+			if !ok {
+				continue
+			}
+			labels := append([]prompb.Label{{Name: "__name__", Value: family.GetName()}}, baseLabels...)
+			series = append(series, prompb.TimeSeries{
+				Labels:  labels,
+				Samples: []prompb.Sample{{Value: value, Timestamp: now}},
+			})
+		}
+	}
+	return series
+}
+
+func metricValue(metric *dto.Metric) (float64, bool) {
+	switch {
+	case metric.Gauge != nil:
+		return metric.Gauge.GetValue(), true
+	case metric.Counter != nil:
+		return metric.Counter.GetValue(), true
+	default:
+		return 0, false
+	}
+}
+
+// histogramSeries expands a histogram sample into the _sum, _count, and one
+// _bucket series per bucket (plus the implicit +Inf bucket) that make up a
+// Prometheus histogram, matching what /metrics exposes instead of
+// collapsing the whole histogram into a single lossy bare-name series.
+func histogramSeries(name string, baseLabels []prompb.Label, h *dto.Histogram, now int64) []prompb.TimeSeries {
+	withName := func(suffix string, extra ...prompb.Label) []prompb.Label {
+		labels := make([]prompb.Label, 0, len(baseLabels)+1+len(extra))
+		labels = append(labels, prompb.Label{Name: "__name__", Value: name + suffix})
+		labels = append(labels, baseLabels...)
+		labels = append(labels, extra...)
+		return labels
+	}
+
+	series := []prompb.TimeSeries{
+		{
+			Labels:  withName("_sum"),
+			Samples: []prompb.Sample{{Value: h.GetSampleSum(), Timestamp: now}},
+		},
+		{
+			Labels:  withName("_count"),
+			Samples: []prompb.Sample{{Value: float64(h.GetSampleCount()), Timestamp: now}},
+		},
+	}
+	for _, b := range h.GetBucket() {
+		series = append(series, prompb.TimeSeries{
+			Labels:  withName("_bucket", prompb.Label{Name: "le", Value: strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64)}),
+			Samples: []prompb.Sample{{Value: float64(b.GetCumulativeCount()), Timestamp: now}},
+		})
+	}
+	series = append(series, prompb.TimeSeries{
+		Labels:  withName("_bucket", prompb.Label{Name: "le", Value: "+Inf"}),
+		Samples: []prompb.Sample{{Value: float64(h.GetSampleCount()), Timestamp: now}},
+	})
+	return series
+}